@@ -59,14 +59,31 @@
 package pollfd
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"runtime"
 	"runtime/netpoll"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unsafe" // for go:linkname, and rawWritev/rawReadv below
 )
 
+// runtime_Semacquire and runtime_Semrelease give access to the
+// runtime's internal semaphore implementation (the same one sync.Mutex
+// is built on), via the linknames the sync package itself uses. They
+// back the csema field of FD, which lets Close block until any
+// in-flight Read/Write/Readv/Writev on the fd has actually returned.
+
+//go:linkname runtime_Semacquire sync.runtime_Semacquire
+func runtime_Semacquire(s *uint32)
+
+//go:linkname runtime_Semrelease sync.runtime_Semrelease
+func runtime_Semrelease(s *uint32, handoff bool, skipframes int)
+
 // Flags to Open
 const (
 	O_RW = (syscall.O_NOCTTY |
@@ -84,6 +101,35 @@ const (
 	o_MODE = 0666
 )
 
+// uioMaxIov is the maximum number of buffers (struct iovec) that
+// writev(2)/readv(2) accept in a single call (UIO_MAXIOV on Linux).
+// Writev and Readv chunk their input/output into calls of at most
+// this many buffers each.
+const uioMaxIov = 1024
+
+// rawWritev and rawReadv issue the writev(2)/readv(2) system-calls
+// directly: the syscall package exports syscall.Iovec but, unlike
+// internal/poll, no portable Writev/Readv wrapper around it, so
+// Writev/Readv below drive the syscall themselves, the same way
+// internal/poll.FD does.
+func rawWritev(fd int, iovecs []syscall.Iovec) (int, error) {
+	r, _, e := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd),
+		uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if e != 0 {
+		return int(r), e
+	}
+	return int(r), nil
+}
+
+func rawReadv(fd int, iovecs []syscall.Iovec) (int, error) {
+	r, _, e := syscall.Syscall(syscall.SYS_READV, uintptr(fd),
+		uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if e != 0 {
+		return int(r), e
+	}
+	return int(r), nil
+}
+
 type temporary interface {
 	Temporary() bool
 }
@@ -112,9 +158,43 @@ func IsErrorTimeout(e error) bool {
 // as well as io.EOF and io.ErrUnexpectedEOF.
 var (
 	ErrTimeout error = netpoll.ErrTimeout // Operation timed-out
-	ErrClosing error = netpoll.ErrClosing // Operation on closed FD
+
+	// ErrFileClosing is returned by operations on an FD that has
+	// already been closed (by a prior call to (*FD).Close, or because
+	// the finalizer ran), including ones that were in-flight (blocked
+	// in Read/Write/Readv/Writev, say) when the close happened.
+	ErrFileClosing error = errors.New("use of closed file")
+
+	// ErrClosing is kept as an alias of ErrFileClosing for
+	// backward-compatibility with code that compares errors against
+	// it directly; new code should prefer ErrFileClosing or
+	// IsErrorClosed.
+	ErrClosing = ErrFileClosing
+
+	// ErrNoDeadline is returned by SetDeadline, SetReadDeadline, and
+	// SetWriteDeadline when the FD's underlying sysfd is not
+	// registered with the netpoller (e.g. after a call to
+	// (*FD).SetBlocking), so no deadline can be enforced on it.
+	ErrNoDeadline error = errors.New("file type does not support deadline")
 )
 
+// IsErrorClosed is a helper function that checks if the argument
+// indicates that the operation was attempted on an FD that has
+// already been closed.
+func IsErrorClosed(err error) bool {
+	return err == ErrFileClosing
+}
+
+// closingErr maps the netpoller's own "closing" error, which
+// PrepareRead/PrepareWrite/WaitRead/WaitWrite return once Close has
+// evicted the fd, to ErrFileClosing; other errors pass through as-is.
+func closingErr(err error) error {
+	if err == netpoll.ErrClosing {
+		return ErrFileClosing
+	}
+	return err
+}
+
 // FD is a file descriptor that can be used with the Go runtime's
 // netpoller subsystem. Typically it is a file-descriptor connected to
 // a terminal, a pseudo terminal, a character device, a FIFO (named
@@ -124,10 +204,74 @@ type FD struct {
 	sysfd int
 	name  string
 	pd    netpoll.PollDesc
+
+	// iovecs and riovecs cache the []syscall.Iovec slices used by
+	// Writev and Readv respectively, so that repeated calls don't
+	// reallocate them. They are each protected by the corresponding
+	// writeLock/readLock, the same as the sysfd itself.
+	iovecs  []syscall.Iovec
+	riovecs []syscall.Iovec
+
+	// isBlocking is set by SetBlocking, once the underlying sysfd has
+	// been switched to blocking mode and evicted from the netpoller.
+	// Read and Write check it (via (*FD).blocking) to skip
+	// PrepareRead/PrepareWrite and the EAGAIN/WaitRead/WaitWrite
+	// dance, since blocking I/O on sysfd can no longer return EAGAIN.
+	// It is an atomic flag, not a plain bool, because SetBlocking and
+	// these checks legitimately run concurrently on different
+	// go-routines, same as the rest of FD.
+	isBlocking uint32
+
+	// zeroReadIsEOF and isStream hold the Options an FD was opened
+	// with (see Options, OpenWithOptions). Read and Write consult them
+	// to decide how to treat 0-byte read(2)/write(2) results and
+	// whether a short write(2) should be retried to completion.
+	zeroReadIsEOF bool
+	isStream      bool
+
+	// csema is released by destroy, once the last reference to the FD
+	// is gone and sysfd has actually been closed. Close acquires it
+	// after evicting the fd from the netpoller, so that it only
+	// returns once no go-routine can still be inside a syscall.Read
+	// or syscall.Write on sysfd -- which matters because sysfd may be
+	// reused by a subsequent Open as soon as Close returns.
+	csema uint32
 }
 
-func newFD(sysfd int, name string) (*FD, error) {
-	fd := &FD{sysfd: sysfd, name: name}
+// Options configures the less common corners of an FD's Read/Write
+// behavior, mirroring the ZeroReadIsEOF/IsStream fields of Go's
+// internal/poll.FD. The zero value is NOT what Open/FromSysfd/
+// NewFromFile use; they preserve today's historical defaults
+// (ZeroReadIsEOF: true, IsStream: true). Use OpenWithOptions to select
+// different values.
+type Options struct {
+	// ZeroReadIsEOF controls whether Read treats a 0-byte read(2) as
+	// io.EOF (the default). Set it to false for message-oriented
+	// descriptors where a 0-length read is a legal empty message
+	// rather than end-of-stream (e.g. some /dev/input/*, TUN/TAP
+	// devices); Read then returns (0, nil) instead.
+	ZeroReadIsEOF bool
+
+	// IsStream controls whether Write retries a short write(2) with
+	// the remainder of p until all of p has been written (the
+	// default). Set it to false for message-oriented descriptors
+	// where each write(2) call sends one discrete message, so Write
+	// should make a single write(2) call and return its result as-is,
+	// instead of looping to complete a "stream" write of p.
+	IsStream bool
+}
+
+// defaultOptions are the Options used by Open, FromSysfd, and
+// NewFromFile, preserving pollfd's original Read/Write semantics.
+var defaultOptions = Options{ZeroReadIsEOF: true, IsStream: true}
+
+func newFD(sysfd int, name string, opt Options) (*FD, error) {
+	fd := &FD{
+		sysfd:         sysfd,
+		name:          name,
+		zeroReadIsEOF: opt.ZeroReadIsEOF,
+		isStream:      opt.IsStream,
+	}
 	if err := fd.pd.Init(uintptr(fd.sysfd)); err != nil {
 		return nil, err
 	}
@@ -138,11 +282,18 @@ func newFD(sysfd int, name string) (*FD, error) {
 // Open the named path for reading, writing or both, depnding on the
 // flags argument.
 func Open(name string, flags int) (*FD, error) {
+	return OpenWithOptions(name, flags, defaultOptions)
+}
+
+// OpenWithOptions is like Open, but lets the caller select the FD's
+// Options (see Options) instead of getting Open's defaults
+// (ZeroReadIsEOF: true, IsStream: true).
+func OpenWithOptions(name string, flags int, opt Options) (*FD, error) {
 	sysfd, err := syscall.Open(name, flags, o_MODE)
 	if err != nil {
 		return nil, err
 	}
-	return newFD(sysfd, name)
+	return newFD(sysfd, name, opt)
 }
 
 // FromSysfd creates, initializes, and returns a pollfd FD from the
@@ -153,7 +304,34 @@ func Open(name string, flags int) (*FD, error) {
 // is used to annotate the FD with a path; if not available it is ok
 // to pass nil.
 func FromSysfd(sysfd int, name string) (*FD, error) {
-	return newFD(sysfd, name)
+	return newFD(sysfd, name, defaultOptions)
+}
+
+// NewFromFile returns a new FD wrapping a dup of the system
+// file-descriptor underlying f. The dup is put in non-blocking mode
+// and registered with the netpoller. Since the dup shares f's open
+// file description, f's own descriptor is in non-blocking mode
+// afterwards as well; it is not closed, and f is still owned by the
+// caller (so, unlike
+// FromSysfd, the caller must continue to Close f separately, in
+// addition to the returned FD). This is the way to get a pollfd.FD for
+// a terminal, FIFO, or character device already opened as an *os.File
+// -- e.g. one obtained from os.OpenFile, os.Stdin, or os.NewFile.
+func NewFromFile(f *os.File) (*FD, error) {
+	sysfd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(sysfd, true); err != nil {
+		syscall.Close(sysfd)
+		return nil, err
+	}
+	fd, err := newFD(sysfd, f.Name(), defaultOptions)
+	if err != nil {
+		syscall.Close(sysfd)
+		return nil, err
+	}
+	return fd, nil
 }
 
 // String returns a text representation of the FD structure formated
@@ -191,7 +369,7 @@ func (fd *FD) Sysfd() int {
 // concurent Close calls issued by other go-routines.
 func (fd *FD) Incref() error {
 	if !fd.fdmu.Incref() {
-		return ErrClosing
+		return ErrFileClosing
 	}
 	return nil
 }
@@ -206,7 +384,7 @@ func (fd *FD) Decref() {
 
 func (fd *FD) readLock() error {
 	if !fd.fdmu.RWLock(true) {
-		return ErrClosing
+		return ErrFileClosing
 	}
 	return nil
 }
@@ -219,7 +397,7 @@ func (fd *FD) readUnlock() {
 
 func (fd *FD) writeLock() error {
 	if !fd.fdmu.RWLock(false) {
-		return ErrClosing
+		return ErrFileClosing
 	}
 	return nil
 }
@@ -230,21 +408,40 @@ func (fd *FD) writeUnlock() {
 	}
 }
 
+// blocking reports whether SetBlocking has switched fd's sysfd to
+// blocking mode. It is read concurrently from Read/Write/Readv/Writev
+// and SetDeadline/SetReadDeadline/SetWriteDeadline on arbitrary
+// go-routines while SetBlocking may be setting it on another, hence
+// the atomic load of isBlocking rather than a plain bool read.
+func (fd *FD) blocking() bool {
+	return atomic.LoadUint32(&fd.isBlocking) != 0
+}
+
 func (fd *FD) destroy() {
 	fd.pd.Close()
 	syscall.Close(fd.sysfd)
 	fd.sysfd = -1
 	runtime.SetFinalizer(fd, nil)
+	runtime_Semrelease(&fd.csema, false, 0)
 }
 
-// Close closes the file descriptor.
+// Close closes the file descriptor. It does not return until no other
+// go-routine is still inside a Read, Write, Readv or Writev call on
+// it: any such call is unblocked (woken with ErrFileClosing), and Close
+// waits for it to actually return before closing sysfd. This matters
+// because, once Close returns, sysfd may be reused by a subsequent
+// Open or FromSysfd call. This guarantee does not hold once
+// (*FD).SetBlocking has been called: a go-routine already inside a
+// blocking syscall.Read or syscall.Write on sysfd cannot be woken up,
+// so Close does not wait for it, and sysfd is only actually closed
+// once that call eventually returns on its own.
 func (fd *FD) Close() error {
 	// TODO(npat): Is this really needed? Currently fd.pd.Lock()
 	// as well as fd.pd.Unlock() and fd.pd.Wakeup() are no-ops.
 	fd.pd.Lock()
 	if !fd.fdmu.IncrefAndClose() {
 		fd.pd.Unlock()
-		return ErrClosing
+		return ErrFileClosing
 	}
 	// Unblock any I/O.  Once it all unblocks and returns,
 	// so that it cannot be referring to fd.sysfd anymore,
@@ -255,6 +452,17 @@ func (fd *FD) Close() error {
 	if doWakeup {
 		fd.pd.Wakeup()
 	}
+	// Wait for destroy to run: it releases csema right after it has
+	// actually closed sysfd, which only happens once every go-routine
+	// that was still reading or writing has dropped its reference.
+	// Skip this when the sysfd is in blocking mode (see SetBlocking):
+	// Evict cannot interrupt a go-routine already inside a blocking
+	// syscall.Read/syscall.Write on sysfd, so that go-routine -- and
+	// thus destroy -- may not run until the blocking call itself
+	// returns (if ever), and waiting for it here would hang Close.
+	if !fd.blocking() {
+		runtime_Semacquire(&fd.csema)
+	}
 	return nil
 }
 
@@ -267,21 +475,27 @@ func (fd *FD) Close() error {
 // details. In addition Read honors the timeout set by
 // (*FD).SetDeadline and (*FD).SetReadDeadline. If no data are read
 // before the timeout expires Read returns with err == ErrTimeout (and
-// n == 0). If the read(2) system-call returns 0, Read returns with
-// err = io.EOF (and n == 0).
+// n == 0). If the read(2) system-call returns 0, Read returns with err
+// = io.EOF (and n == 0); unless the FD was opened with
+// Options.ZeroReadIsEOF false (see OpenWithOptions), in which case it
+// returns (0, nil) instead, for message-oriented descriptors where a
+// 0-length read is a legal empty message rather than end-of-stream.
 func (fd *FD) Read(p []byte) (n int, err error) {
 	if err = fd.readLock(); err != nil {
 		return 0, err
 	}
 	defer fd.readUnlock()
-	if err = fd.pd.PrepareRead(); err != nil {
-		return 0, err
+	defer func() { err = closingErr(err) }()
+	if !fd.blocking() {
+		if err = fd.pd.PrepareRead(); err != nil {
+			return 0, err
+		}
 	}
 	for {
 		n, err = syscall.Read(int(fd.sysfd), p)
 		if err != nil {
 			n = 0
-			if err != syscall.EAGAIN {
+			if fd.blocking() || err != syscall.EAGAIN {
 				break
 			}
 			if err = fd.pd.WaitRead(); err != nil {
@@ -289,7 +503,7 @@ func (fd *FD) Read(p []byte) (n int, err error) {
 			}
 			continue
 		}
-		if n == 0 && len(p) > 0 {
+		if n == 0 && len(p) > 0 && fd.zeroReadIsEOF {
 			err = io.EOF
 		}
 		break
@@ -307,21 +521,30 @@ func (fd *FD) Read(p []byte) (n int, err error) {
 // (*FD).SetDeadline and (*FD).SetWriteDeadline. If less than len(p)
 // data are writen before the timeout expires Write returns with err
 // == ErrTimeout (and n < len(p)). If the write(2) system-call returns
-// 0, Write returns with err == io.ErrUnexpectedEOF.
+// 0, Write returns with err == io.ErrUnexpectedEOF; unless the FD was
+// opened with Options.IsStream false (see OpenWithOptions), in which
+// case Write makes a single write(2) call and returns its result
+// as-is, instead of looping to complete a "stream" write of p -- which
+// is appropriate for message-oriented descriptors where a 0-length
+// write(2) can be a legal empty message and a short write is not an
+// error to retry.
 func (fd *FD) Write(p []byte) (nn int, err error) {
 	if err := fd.writeLock(); err != nil {
 		return 0, err
 	}
 	defer fd.writeUnlock()
-	if err := fd.pd.PrepareWrite(); err != nil {
-		return 0, err
+	defer func() { err = closingErr(err) }()
+	if !fd.blocking() {
+		if err := fd.pd.PrepareWrite(); err != nil {
+			return 0, err
+		}
 	}
 	for {
 		var n int
 		n, err = syscall.Write(fd.sysfd, p[nn:])
 		if err != nil {
 			n = 0
-			if err != syscall.EAGAIN {
+			if fd.blocking() || err != syscall.EAGAIN {
 				break
 			}
 			err = fd.pd.WaitWrite()
@@ -330,6 +553,10 @@ func (fd *FD) Write(p []byte) (nn int, err error) {
 			}
 			continue
 		}
+		if !fd.isStream {
+			nn += n
+			break
+		}
 		if n == 0 {
 			err = io.ErrUnexpectedEOF
 			break
@@ -342,6 +569,177 @@ func (fd *FD) Write(p []byte) (nn int, err error) {
 	return nn, err
 }
 
+// Writev writes the contents of bufs to the file-descriptor using the
+// vectored write(2) system-call (writev(2)), gathering all the
+// buffers into the kernel in as few system-calls as possible instead
+// of copying them into a single buffer first. It returns the total
+// number of bytes written from bufs and any error encountered that
+// caused the write to stop early, following the same semantics as
+// Write: a non-nil error is returned whenever the returned count is
+// less than the sum of len(b) for b in bufs, and the configured write
+// deadline (see (*FD).SetDeadline and (*FD).SetWriteDeadline) is
+// honored the same way, and (see Options, OpenWithOptions) a 0-byte
+// writev(2) is an error (io.ErrUnexpectedEOF) unless the FD was opened
+// with IsStream false, and a short writev(2) is retried to completion
+// unless IsStream is false, in which case Writev makes a single
+// writev(2) call and returns its result as-is. As with Write, callers
+// that need to resume a short or failed Writev must do so using the
+// returned n (to skip the bytes, spanning zero or more whole buffers
+// of bufs, already written) -- bufs itself, passed by value, is left
+// unchanged by the caller's own copy of the slice header. If len(bufs)
+// exceeds the kernel's limit on the number of buffers per writev(2)
+// call (1024 on Linux), Writev issues multiple calls internally.
+func (fd *FD) Writev(bufs [][]byte) (n int64, err error) {
+	if err = fd.writeLock(); err != nil {
+		return 0, err
+	}
+	defer fd.writeUnlock()
+	// Work on our own copy of the []byte headers in bufs: the advance
+	// loop below reslices bufs[0] in place on a partial write, and
+	// without this copy that write would land in the caller's backing
+	// array (the underlying []byte data is not copied, just the
+	// headers, so this is cheap).
+	bufs = append([][]byte(nil), bufs...)
+	defer func() { err = closingErr(err) }()
+	if !fd.blocking() {
+		if err = fd.pd.PrepareWrite(); err != nil {
+			return 0, err
+		}
+	}
+	for len(bufs) > 0 {
+		max := len(bufs)
+		if max > uioMaxIov {
+			max = uioMaxIov
+		}
+		if cap(fd.iovecs) < max {
+			fd.iovecs = make([]syscall.Iovec, max)
+		}
+		iovecs := fd.iovecs[:max]
+		for i := range iovecs {
+			if len(bufs[i]) > 0 {
+				iovecs[i].Base = &bufs[i][0]
+			} else {
+				iovecs[i].Base = nil
+			}
+			iovecs[i].SetLen(len(bufs[i]))
+		}
+		var w int
+		for {
+			w, err = rawWritev(fd.sysfd, iovecs)
+			if err != nil {
+				w = 0
+				if fd.blocking() || err != syscall.EAGAIN {
+					break
+				}
+				if err = fd.pd.WaitWrite(); err != nil {
+					break
+				}
+				continue
+			}
+			break
+		}
+		n += int64(w)
+		if err != nil {
+			break
+		}
+		if !fd.isStream {
+			break
+		}
+		if w == 0 {
+			err = io.ErrUnexpectedEOF
+			break
+		}
+		// Advance bufs past the w bytes just written, dropping every
+		// buffer (empty ones included) that is now fully written.
+		for len(bufs) > 0 && w >= len(bufs[0]) {
+			w -= len(bufs[0])
+			bufs = bufs[1:]
+		}
+		if w > 0 {
+			bufs[0] = bufs[0][w:]
+		}
+	}
+	return n, err
+}
+
+// Readv reads into the buffers in bufs using the vectored read(2)
+// system-call (readv(2)), scattering the data read directly into the
+// supplied buffers instead of reading into a single buffer and
+// copying it out. It returns the total number of bytes read
+// (0 <= n <= sum of len(b) for b in bufs) and any error encountered,
+// following the same semantics as Read: if some data is available but
+// not enough to fill bufs, Readv returns what is available instead of
+// waiting for more, the configured read deadline is honored the same
+// way, and if the readv(2) system-call returns 0, Readv returns with
+// err == io.EOF (and n == 0, or, past the per-call buffer limit below,
+// n counting the buffers filled by earlier readv(2) calls); unless the
+// FD was opened with
+// Options.ZeroReadIsEOF false (see OpenWithOptions), in which case it
+// returns (n, nil) instead. If len(bufs) exceeds the kernel's limit on
+// the number of buffers per readv(2) call (1024 on Linux), Readv
+// issues multiple calls internally, stopping at the first short read.
+func (fd *FD) Readv(bufs [][]byte) (n int64, err error) {
+	if err = fd.readLock(); err != nil {
+		return 0, err
+	}
+	defer fd.readUnlock()
+	defer func() { err = closingErr(err) }()
+	if !fd.blocking() {
+		if err = fd.pd.PrepareRead(); err != nil {
+			return 0, err
+		}
+	}
+	for len(bufs) > 0 {
+		max := len(bufs)
+		if max > uioMaxIov {
+			max = uioMaxIov
+		}
+		if cap(fd.riovecs) < max {
+			fd.riovecs = make([]syscall.Iovec, max)
+		}
+		iovecs := fd.riovecs[:max]
+		want := 0
+		for i := range iovecs {
+			if len(bufs[i]) > 0 {
+				iovecs[i].Base = &bufs[i][0]
+			} else {
+				iovecs[i].Base = nil
+			}
+			iovecs[i].SetLen(len(bufs[i]))
+			want += len(bufs[i])
+		}
+		var r int
+		for {
+			r, err = rawReadv(fd.sysfd, iovecs)
+			if err != nil {
+				r = 0
+				if fd.blocking() || err != syscall.EAGAIN {
+					break
+				}
+				if err = fd.pd.WaitRead(); err != nil {
+					break
+				}
+				continue
+			}
+			break
+		}
+		n += int64(r)
+		if err != nil {
+			break
+		}
+		if r == 0 && want > 0 && fd.zeroReadIsEOF {
+			err = io.EOF
+			break
+		}
+		if r < want {
+			break
+		}
+		// Advance bufs past the fully-read chunk and try the rest.
+		bufs = bufs[max:]
+	}
+	return n, err
+}
+
 // SetDeadline sets the deadline for both Read and Write operations on
 // the file-descriptor. Deadlines are expressed as ABSOLUTE instances
 // in time. Example: To set a timeout 5 seconds in the future do:
@@ -357,12 +755,17 @@ func (fd *FD) Write(p []byte) (nn int, err error) {
 //
 //   fd.SetDeadline(time.Time{})
 //
+// SetDeadline returns ErrNoDeadline if the FD's sysfd is not
+// registered with the netpoller (see (*FD).SetBlocking).
 func (fd *FD) SetDeadline(t time.Time) error {
 	if err := fd.Incref(); err != nil {
 		return err
 	}
+	defer fd.Decref()
+	if fd.blocking() {
+		return ErrNoDeadline
+	}
 	fd.pd.SetDeadline(t, 'r'+'w')
-	fd.Decref()
 	return nil
 }
 
@@ -373,8 +776,11 @@ func (fd *FD) SetReadDeadline(t time.Time) error {
 	if err := fd.Incref(); err != nil {
 		return err
 	}
+	defer fd.Decref()
+	if fd.blocking() {
+		return ErrNoDeadline
+	}
 	fd.pd.SetDeadline(t, 'r')
-	fd.Decref()
 	return nil
 }
 
@@ -385,7 +791,86 @@ func (fd *FD) SetWriteDeadline(t time.Time) error {
 	if err := fd.Incref(); err != nil {
 		return err
 	}
+	defer fd.Decref()
+	if fd.blocking() {
+		return ErrNoDeadline
+	}
 	fd.pd.SetDeadline(t, 'w')
-	fd.Decref()
 	return nil
 }
+
+// SetBlocking switches the underlying system file-descriptor back to
+// blocking mode and removes it from the runtime netpoller. It is
+// meant to be called right before handing the Sysfd off to something
+// that expects traditional blocking I/O: a child process (e.g. after
+// fork/exec), a C library, or an os.File/os.NewFile based API. After a
+// successful call to SetBlocking, Read, Write, Readv and Writev no
+// longer multiplex on the netpoller; a blocked call ties up its
+// calling go-routine (and, potentially, its underlying OS thread)
+// until the I/O completes, deadlines set with SetDeadline and friends
+// are no longer honored, and SetDeadline/SetReadDeadline/
+// SetWriteDeadline return ErrNoDeadline. SetBlocking cannot be undone;
+// once called, the FD should not be used for further non-blocking I/O.
+func (fd *FD) SetBlocking() error {
+	if err := fd.Incref(); err != nil {
+		return err
+	}
+	defer fd.Decref()
+	if err := syscall.SetNonblock(fd.sysfd, false); err != nil {
+		return err
+	}
+	// Evict, not Close: pd is only ever torn down once, by destroy(),
+	// when the FD is actually closed. Evict just deregisters sysfd
+	// from the netpoller (the same call Close uses to interrupt
+	// in-flight I/O) without touching pd's own lifetime.
+	fd.pd.Lock()
+	doWakeup := fd.pd.Evict()
+	fd.pd.Unlock()
+	if doWakeup {
+		fd.pd.Wakeup()
+	}
+	atomic.StoreUint32(&fd.isBlocking, 1)
+	return nil
+}
+
+// Addr is the net.Addr implementation returned by Conn's LocalAddr and
+// RemoteAddr. Since an FD wraps a terminal, FIFO, or character device
+// rather than a network socket, there really is only one "address"
+// involved: the path the FD was opened with.
+type Addr struct {
+	Path string
+}
+
+// Network returns the network type of a, always "pollfd".
+func (a Addr) Network() string { return "pollfd" }
+
+// String returns the path associated with a.
+func (a Addr) String() string { return a.Path }
+
+// Conn adapts an *FD to the net.Conn interface, so that FDs wrapping
+// terminals, FIFOs, and other character devices can be plugged into
+// libraries (RPC frameworks, HTTP over serial, etc.) that are written
+// against net.Conn. Conn embeds *FD, so all of the FD methods
+// (Read, Write, Close, SetDeadline, ...) remain available on it.
+type Conn struct {
+	*FD
+}
+
+// Conn adapts fd to the net.Conn interface. See Conn.
+func (fd *FD) Conn() net.Conn {
+	return Conn{FD: fd}
+}
+
+// LocalAddr returns the Addr built from the path the underlying FD
+// was opened with. Present only to satisfy net.Conn; pollfd FDs have
+// no real notion of a local vs. remote address.
+func (c Conn) LocalAddr() net.Addr {
+	return Addr{Path: c.Name()}
+}
+
+// RemoteAddr returns the Addr built from the path the underlying FD
+// was opened with. Present only to satisfy net.Conn; pollfd FDs have
+// no real notion of a local vs. remote address.
+func (c Conn) RemoteAddr() net.Addr {
+	return Addr{Path: c.Name()}
+}
@@ -1,6 +1,8 @@
 package pollfd
 
 import (
+	"net"
+	"os"
 	"runtime"
 	"syscall"
 	"testing"
@@ -37,6 +39,15 @@ func TestIsError(t *testing.T) {
 	if IsErrorTimeout(ErrClosing) {
 		t.Fatal("ErrClosing is timeout!")
 	}
+	if ErrClosing != ErrFileClosing {
+		t.Fatal("ErrClosing is not an alias of ErrFileClosing!")
+	}
+	if !IsErrorClosed(ErrFileClosing) {
+		t.Fatal("ErrFileClosing not reported as closed!")
+	}
+	if IsErrorClosed(ErrTimeout) {
+		t.Fatal("ErrTimeout reported as closed!")
+	}
 	if IsErrorTemporary(syscall.Errno(syscall.EFAULT)) {
 		t.Fatal("EFAULT is temporary!")
 	}
@@ -150,9 +161,9 @@ func TestReadWrite(t *testing.T) {
 			t.Fatal("SetReadDeadline:", err)
 		}
 		_, err = fdr.Read(b[:1])
-		if err != ErrClosing {
+		if err != ErrFileClosing {
 			done <- true
-			t.Fatal("Expected:", ErrClosing, "- Got:", err)
+			t.Fatal("Expected:", ErrFileClosing, "- Got:", err)
 		}
 		done <- true
 	}()
@@ -182,8 +193,339 @@ func TestReadWrite(t *testing.T) {
 	// Close write-end and test Incref
 	fdw.Close()
 	err = fdw.Incref()
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, "- Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, "- Got:", err)
+	}
+}
+
+func TestWritevReadv(t *testing.T) {
+	mkfifo(t)
+
+	fdr, err := Open(fifo, O_RO)
+	if err != nil {
+		t.Fatal("Open read-side:", err)
+	}
+	fdw, err := Open(fifo, O_WO)
+	if err != nil {
+		t.Fatal("Open write-side:", err)
+	}
+
+	err = fdw.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	if err != nil {
+		t.Fatal("SetWriteDeadline:", err)
+	}
+	bufs := [][]byte{[]byte("Hello, "), []byte("World"), []byte("!")}
+	nw, err := fdw.Writev(bufs)
+	if err != nil {
+		t.Fatal("Writev:", err)
+	}
+	if nw != int64(len("Hello, World!")) {
+		t.Fatal("Writev: short write:", nw)
+	}
+
+	err = fdr.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	b1 := make([]byte, 7)
+	b2 := make([]byte, 6)
+	rbufs := [][]byte{b1, b2}
+	nr, err := fdr.Readv(rbufs)
+	if err != nil {
+		t.Fatal("Readv:", err)
+	}
+	if nr != int64(len(b1)+len(b2)) {
+		t.Fatal("Readv: short read:", nr)
+	}
+	if string(b1)+string(b2) != "Hello, World!" {
+		t.Fatal("Readv: bad data:", string(b1)+string(b2))
+	}
+
+	fdr.Close()
+	fdw.Close()
+}
+
+func TestSetBlocking(t *testing.T) {
+	mkfifo(t)
+
+	fdr, err := Open(fifo, O_RO)
+	if err != nil {
+		t.Fatal("Open read-side:", err)
+	}
+	fdw, err := Open(fifo, O_WO)
+	if err != nil {
+		t.Fatal("Open write-side:", err)
+	}
+	defer fdw.Close()
+
+	if err := fdr.SetBlocking(); err != nil {
+		t.Fatal("SetBlocking:", err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		b := make([]byte, 5)
+		n, err := fdr.Read(b)
+		if err != nil {
+			t.Error("Read:", err)
+		}
+		if string(b[:n]) != "hello" {
+			t.Error("Read: bad data:", string(b[:n]))
+		}
+		done <- true
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := fdw.Write([]byte("hello")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	<-done
+
+	if err := fdr.SetDeadline(time.Now()); err != ErrNoDeadline {
+		t.Fatal("Expected:", ErrNoDeadline, "- Got:", err)
+	}
+	if err := fdr.SetReadDeadline(time.Now()); err != ErrNoDeadline {
+		t.Fatal("Expected:", ErrNoDeadline, "- Got:", err)
+	}
+	if err := fdr.SetWriteDeadline(time.Now()); err != ErrNoDeadline {
+		t.Fatal("Expected:", ErrNoDeadline, "- Got:", err)
+	}
+
+	fdr.Close()
+}
+
+func TestSetBlockingCloseDoesNotHang(t *testing.T) {
+	mkfifo(t)
+
+	fdr, err := Open(fifo, O_RO)
+	if err != nil {
+		t.Fatal("Open read-side:", err)
+	}
+	fdw, err := Open(fifo, O_WO)
+	if err != nil {
+		t.Fatal("Open write-side:", err)
+	}
+	defer fdw.Close()
+
+	if err := fdr.SetBlocking(); err != nil {
+		t.Fatal("SetBlocking:", err)
+	}
+
+	// Get a go-routine genuinely stuck inside a blocking syscall.Read
+	// on fdr's sysfd: Evict cannot wake it up, so Close must not wait
+	// for it (see the guard on (*FD).blocking() in Close).
+	started := make(chan bool)
+	go func() {
+		started <- true
+		b := make([]byte, 1)
+		fdr.Read(b)
+	}()
+	<-started
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- fdr.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal("Close:", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close hung on a blocking-mode FD with in-flight I/O")
+	}
+
+	// Let the leaked reader go-routine return, so it doesn't outlive
+	// the test.
+	fdw.Write([]byte("x"))
+}
+
+func TestCloseDrains(t *testing.T) {
+	mkfifo(t)
+
+	fdr, err := Open(fifo, O_RO)
+	if err != nil {
+		t.Fatal("Open read-side:", err)
+	}
+	// Hold the write-end open, so that the readers below block on an
+	// empty FIFO instead of reading 0 bytes (io.EOF) at once.
+	fdw0, err := Open(fifo, O_WO)
+	if err != nil {
+		t.Fatal("Open write-side:", err)
+	}
+	defer fdw0.Close()
+
+	const nreaders = 8
+	started := make(chan bool, nreaders)
+	done := make(chan error, nreaders)
+	for i := 0; i < nreaders; i++ {
+		go func() {
+			started <- true
+			b := make([]byte, 1)
+			_, err := fdr.Read(b)
+			done <- err
+		}()
+	}
+	for i := 0; i < nreaders; i++ {
+		<-started
+	}
+	// Give the readers a chance to actually block in syscall.Read.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := fdr.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	for i := 0; i < nreaders; i++ {
+		if err := <-done; err != ErrFileClosing {
+			t.Fatal("Expected:", ErrFileClosing, "- Got:", err)
+		}
+	}
+
+	// Close is only supposed to return once every reader has
+	// actually returned from syscall.Read on the old sysfd, so
+	// reopening (which may reuse the same sysfd number) must not
+	// hand any data to, or receive any wakeup meant for, the readers
+	// above.
+	fdr2, err := Open(fifo, O_RO)
+	if err != nil {
+		t.Fatal("re-Open:", err)
+	}
+	fdw, err := Open(fifo, O_WO)
+	if err != nil {
+		t.Fatal("Open write-side:", err)
+	}
+
+	if err := fdr2.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	if _, err := fdw.Write([]byte("x")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	b := make([]byte, 1)
+	n, err := fdr2.Read(b)
+	if err != nil {
+		t.Fatal("Read:", err)
+	}
+	if n != 1 || b[0] != 'x' {
+		t.Fatal("Read: bad data:", n, b)
+	}
+
+	fdr2.Close()
+	fdw.Close()
+}
+
+func TestNewFromFileAndConn(t *testing.T) {
+	mkfifo(t)
+
+	f, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal("os.OpenFile:", err)
+	}
+	defer f.Close()
+
+	fd, err := NewFromFile(f)
+	if err != nil {
+		t.Fatal("NewFromFile:", err)
+	}
+	defer fd.Close()
+
+	var c net.Conn = fd.Conn()
+	if c.LocalAddr().String() != fifo || c.RemoteAddr().String() != fifo {
+		t.Fatal("Conn: bad address:", c.LocalAddr(), c.RemoteAddr())
+	}
+	if c.LocalAddr().Network() != "pollfd" {
+		t.Fatal("Conn: bad network:", c.LocalAddr().Network())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	mkfifo(t)
+
+	opt := Options{ZeroReadIsEOF: false, IsStream: false}
+	fdr, err := OpenWithOptions(fifo, O_RO, opt)
+	if err != nil {
+		t.Fatal("OpenWithOptions read-side:", err)
+	}
+	defer fdr.Close()
+	fdw, err := OpenWithOptions(fifo, O_WO, opt)
+	if err != nil {
+		t.Fatal("OpenWithOptions write-side:", err)
+	}
+	defer fdw.Close()
+
+	if err := fdw.SetWriteDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetWriteDeadline:", err)
+	}
+	nw, err := fdw.Write([]byte("hi"))
+	if err != nil {
+		t.Fatal("Write:", err)
+	}
+	if nw != 2 {
+		t.Fatal("Write: short write:", nw)
+	}
+
+	if err := fdr.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	b := make([]byte, 2)
+	nr, err := fdr.Read(b)
+	if err != nil || nr != 2 || string(b) != "hi" {
+		t.Fatal("Read:", nr, err, string(b))
+	}
+
+	// With ZeroReadIsEOF false, a 0-byte read(2) (simulated here by a
+	// closed write-end) must not be turned into io.EOF.
+	fdw.Close()
+	if err := fdr.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	nr, err = fdr.Read(b)
+	if err != nil || nr != 0 {
+		t.Fatal("Read: expected (0, nil) - got:", nr, err)
+	}
+}
+
+func TestOptionsVectored(t *testing.T) {
+	mkfifo(t)
+
+	opt := Options{ZeroReadIsEOF: false, IsStream: false}
+	fdr, err := OpenWithOptions(fifo, O_RO, opt)
+	if err != nil {
+		t.Fatal("OpenWithOptions read-side:", err)
+	}
+	defer fdr.Close()
+	fdw, err := OpenWithOptions(fifo, O_WO, opt)
+	if err != nil {
+		t.Fatal("OpenWithOptions write-side:", err)
+	}
+
+	if err := fdw.SetWriteDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetWriteDeadline:", err)
+	}
+	bufs := [][]byte{[]byte("hi"), []byte("!")}
+	nw, err := fdw.Writev(bufs)
+	if err != nil || nw != 3 {
+		t.Fatal("Writev:", nw, err)
+	}
+
+	if err := fdr.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	b1 := make([]byte, 2)
+	b2 := make([]byte, 1)
+	nr, err := fdr.Readv([][]byte{b1, b2})
+	if err != nil || nr != 3 || string(b1)+string(b2) != "hi!" {
+		t.Fatal("Readv:", nr, err, string(b1), string(b2))
+	}
+
+	// With ZeroReadIsEOF false, a 0-byte readv(2) (simulated here by a
+	// closed write-end) must not be turned into io.EOF.
+	fdw.Close()
+	if err := fdr.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	nr, err = fdr.Readv([][]byte{b1, b2})
+	if err != nil || nr != 0 {
+		t.Fatal("Readv: expected (0, nil) - got:", nr, err)
 	}
 }
 
@@ -198,32 +540,32 @@ func TestClosed(t *testing.T) {
 		t.Fatal("Close:", err)
 	}
 	err = fdr.Close()
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	b := make([]byte, 10)
 	_, err = fdr.Read(b)
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	_, err = fdr.Write(b)
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	err = fdr.SetReadDeadline(time.Time{})
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	err = fdr.SetWriteDeadline(time.Time{})
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	err = fdr.SetDeadline(time.Time{})
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 	err = fdr.Incref()
-	if err != ErrClosing {
-		t.Fatal("Expected:", ErrClosing, " - Got:", err)
+	if err != ErrFileClosing {
+		t.Fatal("Expected:", ErrFileClosing, " - Got:", err)
 	}
 }